@@ -18,6 +18,7 @@ The netmap binary renders an image with information gathered about a given netwo
 Notes:
 - scans are done with aggressive (-T5 like) config with nmap without sudo privileges
 - make sure you meet the following prerequisites: nmap is installed and findable in $PATH
+- IPv4 and IPv6 networks are both supported; IPv6 networks larger than the image can hold (e.g. a /64) are bucketed onto the available pixels, see getLength
 */
 package main
 
@@ -31,10 +32,14 @@ import (
 	"image/png"
 	"log"
 	"math"
+	"math/big"
 	"net"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/finfinack/netmap/scanner/native"
 	"github.com/google/hilbert"
 )
 
@@ -45,119 +50,174 @@ var (
 	transparent = flag.Bool("transparent", false, "boolean flag whether or not to generate a transparent image")
 	help        = flag.Bool("help", false, "boolean flag to print this help message")
 
-	// Colors defining the gradient in the heatmap. The higher the index, the warmer.
-	colors = map[int]color.RGBA{
-		0: color.RGBA{0, 0, 0, 255},       // black
-		1: color.RGBA{0, 0, 255, 255},     // blue
-		2: color.RGBA{0, 255, 255, 255},   // cyan
-		3: color.RGBA{0, 255, 0, 255},     // green
-		4: color.RGBA{255, 255, 0, 255},   // yellow
-		5: color.RGBA{255, 0, 0, 255},     // red
-		6: color.RGBA{255, 255, 255, 255}, // white
-	}
+	backend           = flag.String("backend", "nmap", "scanner backend to use: nmap, native (a pure-Go scanner that doesn't require the nmap binary); --serve mode always uses nmap")
+	nativeParallelism = flag.Int("native-parallelism", 0, "number of hosts the native backend probes concurrently (0 uses its default)")
+	nativeTimeout     = flag.Duration("native-timeout", 0, "timeout for each probe the native backend sends (0 uses its default)")
+
+	serve           = flag.String("serve", "", "if set, don't write a single heatmap and exit; instead listen on this address (e.g. :8080) and stream live pixel updates to browsers")
+	refreshInterval = flag.Duration("refresh-interval", 5*time.Minute, "in --serve mode, how often to start a full rescan of the network")
+
+	resolve        = flag.String("resolve", "none", "reverse-name resolution to run on found hosts before rendering: none, ptr, mdns, both")
+	resolver       = flag.String("resolver", "", "resolver to use for PTR queries (host:port); empty uses the first nameserver in /etc/resolv.conf, or 8.8.8.8:53 if that can't be read")
+	resolveTimeout = flag.Duration("resolve-timeout", 2*time.Second, "timeout for each PTR/mDNS query")
+
+	paletteName = flag.String("palette", "viridis", "color palette for the heatmap: viridis, magma, inferno, turbo, rainbow")
+	scaleName   = flag.String("scale", "linear", "how raw values are mapped to heatmap levels: linear, log, percentile")
 )
 
-// getColor determines the color of a pixel based on a color gradient and a pixel "level".
-// http://www.andrewnoske.com/wiki/Code_-_heatmaps_and_color_gradients
-func getColor(lvl uint16) color.RGBA {
-	// Return early for the extremes.
-	if lvl <= 0 {
-		return colors[0]
-	} else if lvl >= math.MaxUint16 {
-		return colors[len(colors)-1]
-	}
-	// Find the first color in the gradient where the "level" is higher than the level we're looking for.
-	// Then determine how far along we are between the previous and next color in the gradient and use that
-	// to calculate the color between the two.
-	for i := 0; i < len(colors); i++ {
-		currC := colors[i]
-		currV := uint16(i * math.MaxUint16 / len(colors))
-		if lvl < currV {
-			prevC := colors[int(math.Max(0.0, float64(i-1)))]
-			diff := uint16(math.Max(0.0, float64(i-1)))*math.MaxUint16/uint16(len(colors)) - currV
-			fract := 0.0
-			if diff != 0 {
-				fract = float64(lvl) - float64(currV)/float64(diff)
-			}
-			return color.RGBA{
-				uint8(float64(prevC.R-currC.R)*fract + float64(currC.R)),
-				uint8(float64(prevC.G-currC.G)*fract + float64(currC.G)),
-				uint8(float64(prevC.B-currC.B)*fract + float64(currC.B)),
-				uint8(float64(prevC.A-currC.A)*fract + float64(currC.A)),
-			}
-		}
+// IPToBigInt calculates the big-endian integer value of an IP address, regardless of
+// whether it is an IPv4 or an IPv6 address, without truncation, so it is safe to use
+// for IPv6 host counts which don't fit a uint32 or uint64.
+func IPToBigInt(ip net.IP) *big.Int {
+	if v4 := ip.To4(); v4 != nil {
+		return new(big.Int).SetBytes(v4)
 	}
-	return colors[len(colors)-1]
+	return new(big.Int).SetBytes(ip.To16())
 }
 
-// IPv4ToInt calculates the corresponding uint32 for a given IPv4 address.
-func IPv4ToInt(ip net.IP) uint32 {
-	ip = ip.To4()
-	return uint32(ip[0])<<24 + uint32(ip[1])<<16 + uint32(ip[2])<<8 + uint32(ip[3])
+// maxImageSide bounds the edge length of a heatmap for networks that are too large to
+// map one pixel per host (e.g. an IPv6 /64 has 2^64 hosts). Above this threshold,
+// renderImage buckets multiple hosts onto the same pixel instead of growing the image.
+const maxImageSide = 4096
+
+// log2 returns the base-2 logarithm of l, which must be a power of two.
+func log2(l int) int {
+	b := 0
+	for l > 1 {
+		l >>= 1
+		b++
+	}
+	return b
 }
 
-// getLength calculates the side length of the square image for a given network.
+// getLength calculates the side length of the square image for a given network. For
+// networks small enough to give every host its own pixel (at most 2*log2(maxImageSide)
+// host bits, e.g. an IPv4 /8 or an IPv6 /104) it returns the exact length. Larger
+// networks, such as an IPv6 /64, have far more hosts than any reasonable image can
+// hold; for those it returns maxImageSide and
+// callers must bucket hosts onto pixels instead of mapping them 1:1 (see bucketIndex).
 func getLength(n *net.IPNet) (int, error) {
 	ones, bits := n.Mask.Size()
-	if bits != 32 {
-		return 0, fmt.Errorf("the given network is not an IPv4 network: %v", n)
+	if bits != 32 && bits != 128 {
+		return 0, fmt.Errorf("the given network is not an IPv4 or IPv6 network: %v", n)
 	}
-	l := math.Sqrt(math.Pow(float64(2), float64(bits-ones)))
+	hostBits := bits - ones
+	if hostBits > 2*log2(maxImageSide) {
+		return maxImageSide, nil
+	}
+	l := math.Sqrt(math.Pow(float64(2), float64(hostBits)))
 	if l != math.Ceil(l) {
 		return 0, fmt.Errorf("please choose a network that allows a square image (e.g. /24, /16).")
 	}
 	return int(l), nil
 }
 
-// renderImage renders an image for a given network with a list of hosts.
-func renderImage(n *net.IPNet, t bool, st ScanType, hosts []Host) (image.Image, error) {
-	l, _ := getLength(n)
-	canvas := image.NewRGBA(image.Rectangle{
-		Min: image.Point{0, 0},
-		Max: image.Point{l, l},
-	})
-	if !t {
-		draw.Draw(canvas, canvas.Bounds(), &image.Uniform{colors[0]}, image.ZP, draw.Src)
+// bucketIndex truncates the offset of ip within n down to the top 2*log2(l) bits, so
+// it can be mapped 1:1 onto an l x l Hilbert curve even when n has far more hosts than
+// pixels (e.g. an IPv6 /64). Hosts whose truncated offset collides end up on the same
+// pixel and are expected to be aggregated by the caller.
+func bucketIndex(n *net.IPNet, ip net.IP, l int) *big.Int {
+	offset := new(big.Int).Sub(IPToBigInt(ip), IPToBigInt(n.IP))
+	ones, bits := n.Mask.Size()
+	hostBits := bits - ones
+	bucketBits := 2 * log2(l)
+	if bucketBits >= hostBits {
+		return offset
 	}
+	return offset.Rsh(offset, uint(hostBits-bucketBits))
+}
 
-	hil, err := hilbert.New(int(l))
+// pixelCoord identifies a single pixel on the canvas.
+type pixelCoord struct {
+	x, y int
+}
+
+// Canvas is a heatmap image that can be built up one host at a time via
+// UpdatePixel, rather than only in one shot like renderImage. It's safe for
+// concurrent use, which --serve relies on to push live pixel updates while a scan
+// is still running.
+type Canvas struct {
+	mu  sync.Mutex
+	img *image.RGBA
+	n   *net.IPNet
+	hil *hilbert.Hilbert
+	l   int
+	pal Palette
+}
+
+// NewCanvas creates a Canvas sized for n, coloring with pal. Unless transparent is
+// set, it's pre-filled with pal's color at level 0, just like renderImage's
+// non-transparent mode.
+func NewCanvas(n *net.IPNet, transparent bool, pal Palette) (*Canvas, error) {
+	l, err := getLength(n)
+	if err != nil {
+		return nil, err
+	}
+	hil, err := hilbert.NewHilbert(l)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create hilbert map for given network: %s", err)
 	}
+	img := image.NewRGBA(image.Rectangle{Max: image.Point{l, l}})
+	if !transparent {
+		draw.Draw(img, img.Bounds(), &image.Uniform{pal.Color(0)}, image.ZP, draw.Src)
+	}
+	return &Canvas{img: img, n: n, hil: hil, l: l, pal: pal}, nil
+}
 
-	startIP := IPv4ToInt(n.IP)
-	max := 0
-	switch st {
-	case ScanHostUp:
-		for _, h := range hosts {
-			if h.RTT > max {
-				max = h.RTT
-			}
-		}
-	default:
-		for _, h := range hosts {
-			if len(h.OpenPorts) > max {
-				max = len(h.OpenPorts)
-			}
-		}
+// UpdatePixel recolors the pixel that ip's bucket (see bucketIndex) maps to and
+// returns its coordinates and new color, so a caller can push just that delta to
+// subscribers instead of re-rendering the whole image.
+func (c *Canvas) UpdatePixel(ip net.IP, lvl uint16) (x, y int, col color.RGBA, err error) {
+	idx := bucketIndex(c.n, ip, c.l)
+	x, y, err = c.hil.Map(int(idx.Int64()))
+	if err != nil {
+		return 0, 0, color.RGBA{}, err
+	}
+	col = c.pal.Color(lvl)
+	c.mu.Lock()
+	c.img.SetRGBA(x, y, col)
+	c.mu.Unlock()
+	return x, y, col, nil
+}
+
+// Image returns a snapshot of the canvas' current image.
+func (c *Canvas) Image() image.Image {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snap := image.NewRGBA(c.img.Bounds())
+	draw.Draw(snap, snap.Bounds(), c.img, image.ZP, draw.Src)
+	return snap
+}
+
+// renderImage renders an image for a given network with a list of hosts. Hosts that
+// bucket onto the same pixel (see bucketIndex) have their values summed, so a handful
+// of pings into an otherwise-idle IPv6 /64 still light up the expected pixels. scale
+// controls how those summed values are mapped onto pal's levels.
+func renderImage(n *net.IPNet, t bool, st ScanType, hosts []Host, scale ScaleMode, pal Palette) (image.Image, error) {
+	canvas, err := NewCanvas(n, t, pal)
+	if err != nil {
+		return nil, err
 	}
 
+	values := map[pixelCoord]int{}
 	for _, h := range hosts {
-		t := IPv4ToInt(h.IP) - startIP
-		x, y, err := hil.Map(int(t))
+		idx := bucketIndex(n, h.IP, canvas.l)
+		x, y, err := canvas.hil.Map(int(idx.Int64()))
 		if err != nil {
 			continue
 		}
-		lvl := uint16(0)
 		switch st {
 		case ScanHostUp:
-			lvl = uint16(h.RTT * int(math.MaxUint16) / max)
+			values[pixelCoord{x, y}] += h.RTT
 		default:
-			lvl = uint16(len(h.OpenPorts) * int(math.MaxUint16) / max)
+			values[pixelCoord{x, y}] += len(h.OpenPorts)
 		}
-		canvas.SetRGBA(x, y, getColor(lvl))
 	}
-	return canvas, nil
+
+	for pc, lvl := range scaleValues(values, scale) {
+		canvas.img.SetRGBA(pc.x, pc.y, pal.Color(lvl))
+	}
+	return canvas.Image(), nil
 }
 
 // writeImage writes a given image to the given path.
@@ -188,6 +248,30 @@ func printUsage(m string, fatal bool) {
 	}
 }
 
+// runScan dispatches to the scanner backend selected via --backend and normalizes
+// its result to this package's Host type.
+func runScan(n *net.IPNet, t ScanType) ([]Host, error) {
+	switch strings.ToLower(*backend) {
+	case "", "nmap":
+		return Scan(n, t)
+	case "native":
+		nHosts, err := native.Scan(n, native.ScanType(t), native.Options{
+			Parallelism: *nativeParallelism,
+			Timeout:     *nativeTimeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+		hosts := make([]Host, len(nHosts))
+		for i, nh := range nHosts {
+			hosts[i] = Host{IP: nh.IP, RTT: nh.RTT, OpenPorts: nh.OpenPorts}
+		}
+		return hosts, nil
+	default:
+		return nil, fmt.Errorf("unknown backend: %s", *backend)
+	}
+}
+
 func main() {
 	flag.Parse()
 
@@ -195,12 +279,30 @@ func main() {
 		printUsage("", false)
 	}
 
+	pal, ok := palettes[strings.ToLower(*paletteName)]
+	if !ok {
+		printUsage(fmt.Sprintf("unknown palette: %s", *paletteName), true)
+	}
+	scale := ScaleMode(strings.ToUpper(*scaleName))
+
+	if *serve != "" {
+		_, n, err := net.ParseCIDR(*network)
+		if err != nil {
+			printUsage(fmt.Sprintf("the given network does not parse: %s", err), true)
+		}
+		scantype := ScanType(strings.ToUpper(*scantype))
+		if err := serveLive(*serve, n, scantype, *transparent, pal, scale, *refreshInterval); err != nil {
+			log.Fatalf("serve failed: %s", err)
+		}
+		return
+	}
+
 	// Check given parameters and prepare
 	if *file == "" {
 		printUsage("please specify a filename and path to store the heatmap.", true)
 	}
-	if !strings.HasSuffix(*file, ".jpg") && !strings.HasSuffix(*file, ".png") {
-		printUsage("please use .jpg or .png files for the heatmap.", true)
+	if !strings.HasSuffix(*file, ".jpg") && !strings.HasSuffix(*file, ".png") && !strings.HasSuffix(*file, ".svg") {
+		printUsage("please use .jpg, .png or .svg files for the heatmap.", true)
 	}
 	_, n, err := net.ParseCIDR(*network)
 	if err != nil {
@@ -208,12 +310,28 @@ func main() {
 	}
 
 	scantype := ScanType(strings.ToUpper(*scantype))
-	hosts, err := Scan(n, scantype)
+	hosts, err := runScan(n, scantype)
 	if err != nil {
 		printUsage(fmt.Sprintf("scan failed: %s", err), true)
 	}
 
-	canvas, err := renderImage(n, *transparent, scantype, hosts)
+	resolveMode := ResolveMode(strings.ToUpper(*resolve))
+	if resolveMode != ResolveNone {
+		res := *resolver
+		if res == "" {
+			res = systemResolver()
+		}
+		resolveHosts(hosts, resolveMode, res, *resolveTimeout)
+	}
+
+	if strings.HasSuffix(*file, ".svg") {
+		if err := writeSVG(*file, n, scantype, hosts, scale, pal, *transparent); err != nil {
+			log.Fatalf("unable to write svg heatmap: %s", err)
+		}
+		return
+	}
+
+	canvas, err := renderImage(n, *transparent, scantype, hosts, scale, pal)
 	if err != nil {
 		log.Fatalf("unable to render image: %s", err)
 	}
@@ -0,0 +1,80 @@
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestGetLength(t *testing.T) {
+	tests := []struct {
+		cidr    string
+		want    int
+		wantErr bool
+	}{
+		{cidr: "10.0.0.0/24", want: 16},
+		{cidr: "10.0.0.0/16", want: 256},
+		{cidr: "2001:db8::/120", want: 16},
+		{cidr: "2001:db8::/64", want: maxImageSide},
+		{cidr: "10.0.0.0/23", wantErr: true}, // 2^9 hosts doesn't give a square image
+	}
+	for _, tc := range tests {
+		_, n, err := net.ParseCIDR(tc.cidr)
+		if err != nil {
+			t.Fatalf("ParseCIDR(%q): %s", tc.cidr, err)
+		}
+		got, err := getLength(n)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("getLength(%s) error = %v, wantErr %v", tc.cidr, err, tc.wantErr)
+			continue
+		}
+		if err == nil && got != tc.want {
+			t.Errorf("getLength(%s) = %d, want %d", tc.cidr, got, tc.want)
+		}
+	}
+}
+
+func TestBucketIndex(t *testing.T) {
+	_, n, err := net.ParseCIDR("10.0.0.0/24")
+	if err != nil {
+		t.Fatalf("ParseCIDR: %s", err)
+	}
+	l, err := getLength(n)
+	if err != nil {
+		t.Fatalf("getLength: %s", err)
+	}
+	// /24 has exactly l*l hosts, so no truncation happens and every host gets its own
+	// bucket, in order.
+	for i := 0; i < 5; i++ {
+		ip := net.ParseIP(n.IP.String()).To4()
+		ip[3] += byte(i)
+		if got := bucketIndex(n, ip, l).Int64(); got != int64(i) {
+			t.Errorf("bucketIndex(%s, +%d) = %d, want %d", n, i, got, i)
+		}
+	}
+
+	// A /64 has far more hosts than maxImageSide*maxImageSide pixels, so distinct hosts
+	// sharing the truncated high bits must collide onto the same bucket.
+	_, n6, err := net.ParseCIDR("2001:db8::/64")
+	if err != nil {
+		t.Fatalf("ParseCIDR: %s", err)
+	}
+	a := net.ParseIP("2001:db8::1")
+	b := net.ParseIP("2001:db8::2")
+	if bucketIndex(n6, a, maxImageSide).Cmp(bucketIndex(n6, b, maxImageSide)) != 0 {
+		t.Errorf("bucketIndex(%s) expected ::1 and ::2 to collide into the same bucket", n6)
+	}
+}
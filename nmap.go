@@ -58,6 +58,33 @@ type xnHost struct {
 	Ports []xnPorts `xml:"ports"`
 }
 
+// hostFromXn converts a decoded <host> element into a Host. If the element carries no
+// ipv4 or ipv6 address, the returned Host's IP is nil.
+func hostFromXn(xh xnHost) Host {
+	h := Host{}
+	for _, xa := range xh.Addrs {
+		switch {
+		case xa.Type == "ipv4", xa.Type == "ipv6":
+			h.IP = net.ParseIP(xa.Addr)
+		}
+	}
+	for _, xt := range xh.Times {
+		i, err := strconv.Atoi(xt.RTT)
+		if err != nil {
+			continue
+		}
+		h.RTT = i // microseconds
+	}
+	for _, xps := range xh.Ports {
+		for _, xp := range xps.Port {
+			if xp.State[0].State == "open" {
+				h.OpenPorts = append(h.OpenPorts, fmt.Sprintf("%s/%s", xp.Proto, xp.Id))
+			}
+		}
+	}
+	return h
+}
+
 // parseXml reads an XML from a reader and parses Host information out of it.
 func parseXml(reader io.Reader) ([]Host, error) {
 	doc := xnDoc{}
@@ -70,27 +97,7 @@ func parseXml(reader io.Reader) ([]Host, error) {
 
 	var hosts []Host
 	for _, xh := range doc.Hosts {
-		h := Host{}
-		for _, xa := range xh.Addrs {
-			switch {
-			case xa.Type == "ipv4":
-				h.IP = net.ParseIP(xa.Addr)
-			}
-		}
-		for _, xt := range xh.Times {
-			i, err := strconv.Atoi(xt.RTT)
-			if err != nil {
-				continue
-			}
-			h.RTT = i // microseconds
-		}
-		for _, xps := range xh.Ports {
-			for _, xp := range xps.Port {
-				if xp.State[0].State == "open" {
-					h.OpenPorts = append(h.OpenPorts, fmt.Sprintf("%s/%s", xp.Proto, xp.Id))
-				}
-			}
-		}
+		h := hostFromXn(xh)
 		if h.IP.String() != "" {
 			hosts = append(hosts, h)
 		}
@@ -99,11 +106,44 @@ func parseXml(reader io.Reader) ([]Host, error) {
 	return hosts, nil
 }
 
+// parseXmlStream decodes an nmap XML stream incrementally, invoking fn for every
+// <host> element as soon as it's fully read, instead of waiting for the whole
+// document to be decoded like parseXml does. This lets callers react to scan results
+// as they arrive, which --serve uses to push live pixel updates.
+func parseXmlStream(reader io.Reader, fn func(Host)) error {
+	dec := xml.NewDecoder(reader)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "host" {
+			continue
+		}
+		var xh xnHost
+		if err := dec.DecodeElement(&xh, &se); err != nil {
+			return err
+		}
+		h := hostFromXn(xh)
+		if h.IP.String() != "" {
+			fn(h)
+		}
+	}
+}
+
 // Host represents a single finding inside the nmap scan results.
 type Host struct {
 	IP        net.IP
 	RTT       int
 	OpenPorts []string
+
+	// Name is the host's reverse-DNS or mDNS name, filled in by resolveHosts when
+	// --resolve is set. It's empty otherwise.
+	Name string
 }
 
 // ScanType defines the parameters the scan is run with.
@@ -123,8 +163,8 @@ const (
 	ScanWebPorts = "WEBPORTS"
 )
 
-// Scan runs an nmap scan against a given network and returns a Host struct for every finding.
-func Scan(n *net.IPNet, t ScanType) ([]Host, error) {
+// scanArgs builds the nmap command-line arguments for a scan of n of type t.
+func scanArgs(n *net.IPNet, t ScanType) ([]string, error) {
 	args := []string{
 		n.String(), "-oX", "-", "-n", "--open",
 		// Basically -T5 with minor tweaks
@@ -134,6 +174,9 @@ func Scan(n *net.IPNet, t ScanType) ([]Host, error) {
 		"--max-retries=1",
 		"--host-timeout=5m",
 	}
+	if _, bits := n.Mask.Size(); bits == 128 {
+		args = append(args, "-6")
+	}
 	switch t {
 	case ScanHostUp:
 		args = append(args, "-sP")
@@ -147,7 +190,16 @@ func Scan(n *net.IPNet, t ScanType) ([]Host, error) {
 		args = append(args, "-sT")
 		args = append(args, "-p80,443")
 	default:
-		return []Host{}, fmt.Errorf("unknown scan type: %v", t)
+		return nil, fmt.Errorf("unknown scan type: %v", t)
+	}
+	return args, nil
+}
+
+// Scan runs an nmap scan against a given network and returns a Host struct for every finding.
+func Scan(n *net.IPNet, t ScanType) ([]Host, error) {
+	args, err := scanArgs(n, t)
+	if err != nil {
+		return []Host{}, err
 	}
 	c := exec.Command("nmap", args...)
 	fmt.Printf("running scan: %+v\n", strings.Join(c.Args, " "))
@@ -158,3 +210,30 @@ func Scan(n *net.IPNet, t ScanType) ([]Host, error) {
 
 	return parseXml(bytes.NewReader(cout))
 }
+
+// ScanStream behaves like Scan but invokes fn for every host as nmap reports it,
+// rather than waiting for the whole scan to finish and decoding one XML document.
+// It powers --serve's live heatmap updates.
+func ScanStream(n *net.IPNet, t ScanType, fn func(Host)) error {
+	args, err := scanArgs(n, t)
+	if err != nil {
+		return err
+	}
+	c := exec.Command("nmap", args...)
+	fmt.Printf("running scan: %+v\n", strings.Join(c.Args, " "))
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("unable to attach to scan output: %s", err)
+	}
+	if err := c.Start(); err != nil {
+		return fmt.Errorf("error starting scan: %s", err)
+	}
+	if err := parseXmlStream(stdout, fn); err != nil {
+		c.Wait()
+		return fmt.Errorf("error parsing scan output: %s", err)
+	}
+	if err := c.Wait(); err != nil {
+		return fmt.Errorf("error during scan: %s", err)
+	}
+	return nil
+}
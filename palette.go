@@ -0,0 +1,123 @@
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image/color"
+	"math"
+)
+
+// Palette maps a heatmap level in [0, MaxUint16] to a color. It replaces the old
+// fixed rainbow gradient and hardcoded getColor, so renderImage, writeSVG and Canvas
+// all plug into whichever palette --palette selects.
+type Palette interface {
+	Color(lvl uint16) color.RGBA
+}
+
+// gradientPalette is a Palette built from an ordered list of evenly spaced color
+// stops. Colors between stops are linearly interpolated in linear-light sRGB space
+// rather than gamma-compressed sRGB, so intermediate colors don't come out darker or
+// muddier than either endpoint would suggest.
+type gradientPalette struct {
+	stops []color.RGBA
+}
+
+func (p gradientPalette) Color(lvl uint16) color.RGBA {
+	if len(p.stops) == 0 {
+		return color.RGBA{A: 255}
+	}
+	if len(p.stops) == 1 || lvl <= 0 {
+		return p.stops[0]
+	}
+	if lvl >= math.MaxUint16 {
+		return p.stops[len(p.stops)-1]
+	}
+
+	pos := float64(lvl) / float64(math.MaxUint16) * float64(len(p.stops)-1)
+	i := int(pos)
+	if i >= len(p.stops)-1 {
+		return p.stops[len(p.stops)-1]
+	}
+	fract := pos - float64(i)
+	a, b := p.stops[i], p.stops[i+1]
+	return color.RGBA{
+		R: linearToSRGB(lerp(srgbToLinear(a.R), srgbToLinear(b.R), fract)),
+		G: linearToSRGB(lerp(srgbToLinear(a.G), srgbToLinear(b.G), fract)),
+		B: linearToSRGB(lerp(srgbToLinear(a.B), srgbToLinear(b.B), fract)),
+		A: uint8(lerp(float64(a.A), float64(b.A), fract)),
+	}
+}
+
+func lerp(a, b, fract float64) float64 { return a + (b-a)*fract }
+
+// srgbToLinear converts an 8-bit sRGB channel value to linear light in [0, 1].
+func srgbToLinear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB converts a linear-light channel value in [0, 1] back to 8-bit sRGB.
+func linearToSRGB(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 255
+	}
+	var s float64
+	if v <= 0.0031308 {
+		s = v * 12.92
+	} else {
+		s = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(s * 255))
+}
+
+func rgb(r, g, b uint8) color.RGBA { return color.RGBA{r, g, b, 255} }
+
+// palettes are the built-in Palettes, selectable via --palette. viridis, magma,
+// inferno and turbo are the perceptually uniform maps that have superseded rainbow
+// in scientific visualization, since rainbow's luminance isn't monotonic and can
+// make the heatmap imply features that aren't in the data; rainbow is kept for
+// those who still want it.
+var palettes = map[string]Palette{
+	"rainbow": gradientPalette{stops: []color.RGBA{
+		rgb(0, 0, 0), rgb(0, 0, 255), rgb(0, 255, 255), rgb(0, 255, 0),
+		rgb(255, 255, 0), rgb(255, 0, 0), rgb(255, 255, 255),
+	}},
+	"viridis": gradientPalette{stops: []color.RGBA{
+		rgb(0x44, 0x01, 0x54), rgb(0x47, 0x2d, 0x7b), rgb(0x3b, 0x52, 0x8b),
+		rgb(0x2c, 0x72, 0x8e), rgb(0x21, 0x91, 0x8c), rgb(0x28, 0xae, 0x80),
+		rgb(0x5e, 0xc9, 0x62), rgb(0xad, 0xdc, 0x30), rgb(0xfd, 0xe7, 0x25),
+	}},
+	"magma": gradientPalette{stops: []color.RGBA{
+		rgb(0x00, 0x00, 0x04), rgb(0x1c, 0x10, 0x44), rgb(0x4f, 0x12, 0x7b),
+		rgb(0x81, 0x25, 0x81), rgb(0xb5, 0x36, 0x7a), rgb(0xe5, 0x50, 0x64),
+		rgb(0xfb, 0x87, 0x61), rgb(0xfe, 0xc2, 0x87), rgb(0xfc, 0xfd, 0xbf),
+	}},
+	"inferno": gradientPalette{stops: []color.RGBA{
+		rgb(0x00, 0x00, 0x04), rgb(0x21, 0x0c, 0x4a), rgb(0x57, 0x10, 0x6e),
+		rgb(0x8a, 0x22, 0x6a), rgb(0xbc, 0x37, 0x54), rgb(0xe3, 0x59, 0x33),
+		rgb(0xfc, 0xa5, 0x0a), rgb(0xfc, 0xff, 0xa4),
+	}},
+	"turbo": gradientPalette{stops: []color.RGBA{
+		rgb(0x30, 0x12, 0x3b), rgb(0x46, 0x69, 0xdd), rgb(0x28, 0xbc, 0xeb),
+		rgb(0x1a, 0xe4, 0xb6), rgb(0x72, 0xfe, 0x5e), rgb(0xc9, 0xef, 0x34),
+		rgb(0xfe, 0xc3, 0x3d), rgb(0xf6, 0x6d, 0x19), rgb(0x9e, 0x01, 0x42),
+	}},
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestGradientPaletteEndpoints(t *testing.T) {
+	for name, pal := range palettes {
+		stops := pal.(gradientPalette).stops
+		if got := pal.Color(0); got != stops[0] {
+			t.Errorf("%s.Color(0) = %v, want first stop %v", name, got, stops[0])
+		}
+		if got := pal.Color(math.MaxUint16); got != stops[len(stops)-1] {
+			t.Errorf("%s.Color(MaxUint16) = %v, want last stop %v", name, got, stops[len(stops)-1])
+		}
+	}
+}
+
+func TestGradientPaletteInterpolates(t *testing.T) {
+	// Two stops a known distance apart in linear-light space, so the midpoint level
+	// should land exactly halfway between them once converted back to sRGB.
+	pal := gradientPalette{stops: []color.RGBA{rgb(0, 0, 0), rgb(255, 255, 255)}}
+	mid := pal.Color(math.MaxUint16 / 2)
+	want := linearToSRGB(lerp(srgbToLinear(0), srgbToLinear(255), 0.5))
+	if mid.R != want || mid.G != want || mid.B != want {
+		t.Errorf("gradientPalette midpoint = %v, want (%d,%d,%d)", mid, want, want, want)
+	}
+}
+
+func TestGradientPaletteEmpty(t *testing.T) {
+	pal := gradientPalette{}
+	if got := pal.Color(1234); got != (color.RGBA{A: 255}) {
+		t.Errorf("empty gradientPalette.Color(1234) = %v, want opaque black", got)
+	}
+}
+
+func TestSRGBLinearRoundTrip(t *testing.T) {
+	for c := 0; c <= 255; c++ {
+		got := linearToSRGB(srgbToLinear(uint8(c)))
+		if diff := int(got) - c; diff < -1 || diff > 1 {
+			t.Errorf("linearToSRGB(srgbToLinear(%d)) = %d, want within 1 of %d", c, got, c)
+		}
+	}
+}
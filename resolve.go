@@ -0,0 +1,108 @@
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ResolveMode selects which name-resolution methods resolveHosts runs.
+type ResolveMode string
+
+const (
+	// ResolveNone skips name resolution entirely.
+	ResolveNone ResolveMode = "NONE"
+
+	// ResolvePTR resolves hosts via a regular reverse-DNS (PTR) query.
+	ResolvePTR ResolveMode = "PTR"
+
+	// ResolveMDNS resolves hosts via mDNS, for link-local subnets that have no
+	// PTR records upstream.
+	ResolveMDNS ResolveMode = "MDNS"
+
+	// ResolveBoth tries PTR first and falls back to mDNS.
+	ResolveBoth ResolveMode = "BOTH"
+)
+
+// mdnsAddr is the well-known multicast address and port mDNS responders listen on.
+const mdnsAddr = "224.0.0.251:5353"
+
+// resolveHosts fills in the Name field of every host in hosts in place, concurrently
+// issuing PTR and/or mDNS queries depending on mode. Hosts that don't resolve are
+// left with an empty Name.
+func resolveHosts(hosts []Host, mode ResolveMode, resolver string, timeout time.Duration) {
+	if mode == ResolveNone || mode == "" {
+		return
+	}
+	var wg sync.WaitGroup
+	for i := range hosts {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if mode == ResolvePTR || mode == ResolveBoth {
+				if name, err := ptrLookup(hosts[i].IP, resolver, timeout); err == nil {
+					hosts[i].Name = name
+					return
+				}
+			}
+			if mode == ResolveMDNS || mode == ResolveBoth {
+				if name, err := ptrLookup(hosts[i].IP, mdnsAddr, timeout); err == nil {
+					hosts[i].Name = name
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ptrLookup issues a single PTR query for ip against server (host:port) and returns
+// the first name in the response. The same query shape resolves both regular
+// reverse-DNS and mDNS names; only the server differs (see mdnsAddr).
+func ptrLookup(ip net.IP, server string, timeout time.Duration) (string, error) {
+	arpa, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return "", err
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(arpa, dns.TypePTR)
+	c := &dns.Client{Timeout: timeout}
+	r, _, err := c.Exchange(m, server)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range r.Answer {
+		if ptr, ok := a.(*dns.PTR); ok {
+			return strings.TrimSuffix(ptr.Ptr, "."), nil
+		}
+	}
+	return "", fmt.Errorf("no PTR record for %s", ip)
+}
+
+// systemResolver returns the first nameserver from /etc/resolv.conf as a host:port
+// string, falling back to a public resolver if it can't be read.
+func systemResolver() string {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(conf.Servers) == 0 {
+		return "8.8.8.8:53"
+	}
+	return net.JoinHostPort(conf.Servers[0], conf.Port)
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// ScaleMode selects how raw per-pixel values (RTTs or open-port counts) are mapped
+// onto the [0, MaxUint16] levels a Palette operates on.
+type ScaleMode string
+
+const (
+	// ScaleLinear scales values proportionally to the maximum observed value.
+	ScaleLinear ScaleMode = "LINEAR"
+
+	// ScaleLog compresses the high end of the range so a handful of slow hosts or
+	// hosts with unusually many open ports don't wash out the rest of the heatmap.
+	ScaleLog ScaleMode = "LOG"
+
+	// ScalePercentile ranks values by their position in the sorted set, so the
+	// heatmap reflects hosts' relative standing rather than absolute magnitude.
+	ScalePercentile ScaleMode = "PERCENTILE"
+)
+
+// scaleValues maps each pixel's raw aggregated value to a heatmap level according to
+// mode. Pixels with no value (empty values map) yield an empty result.
+func scaleValues(values map[pixelCoord]int, mode ScaleMode) map[pixelCoord]uint16 {
+	switch mode {
+	case ScaleLog:
+		return logScale(values)
+	case ScalePercentile:
+		return percentileScale(values)
+	default:
+		return linearScale(values)
+	}
+}
+
+func linearScale(values map[pixelCoord]int) map[pixelCoord]uint16 {
+	max := 0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	lvls := make(map[pixelCoord]uint16, len(values))
+	if max == 0 {
+		return lvls
+	}
+	for pc, v := range values {
+		lvls[pc] = uint16(v * int(math.MaxUint16) / max)
+	}
+	return lvls
+}
+
+func logScale(values map[pixelCoord]int) map[pixelCoord]uint16 {
+	max := 0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	lvls := make(map[pixelCoord]uint16, len(values))
+	if max == 0 {
+		return lvls
+	}
+	denom := math.Log1p(float64(max))
+	for pc, v := range values {
+		lvls[pc] = uint16(math.Log1p(float64(v)) / denom * math.MaxUint16)
+	}
+	return lvls
+}
+
+func percentileScale(values map[pixelCoord]int) map[pixelCoord]uint16 {
+	lvls := make(map[pixelCoord]uint16, len(values))
+	if len(values) == 0 {
+		return lvls
+	}
+	type ranked struct {
+		pc pixelCoord
+		v  int
+	}
+	sorted := make([]ranked, 0, len(values))
+	for pc, v := range values {
+		sorted = append(sorted, ranked{pc, v})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].v < sorted[j].v })
+	for rank, e := range sorted {
+		lvls[e.pc] = uint16(rank * int(math.MaxUint16) / max(1, len(sorted)-1))
+	}
+	return lvls
+}
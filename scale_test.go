@@ -0,0 +1,90 @@
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScaleValuesEmpty(t *testing.T) {
+	for _, mode := range []ScaleMode{ScaleLinear, ScaleLog, ScalePercentile} {
+		if got := scaleValues(map[pixelCoord]int{}, mode); len(got) != 0 {
+			t.Errorf("scaleValues(empty, %s) = %v, want empty", mode, got)
+		}
+	}
+}
+
+func TestLinearScale(t *testing.T) {
+	values := map[pixelCoord]int{
+		{0, 0}: 0,
+		{1, 0}: 50,
+		{2, 0}: 100,
+	}
+	got := linearScale(values)
+	if got[pixelCoord{0, 0}] != 0 {
+		t.Errorf("linearScale min = %d, want 0", got[pixelCoord{0, 0}])
+	}
+	if got[pixelCoord{2, 0}] != math.MaxUint16 {
+		t.Errorf("linearScale max = %d, want %d", got[pixelCoord{2, 0}], math.MaxUint16)
+	}
+	if got[pixelCoord{1, 0}] != math.MaxUint16/2 {
+		t.Errorf("linearScale midpoint = %d, want %d", got[pixelCoord{1, 0}], math.MaxUint16/2)
+	}
+}
+
+func TestLogScaleCompressesHighEnd(t *testing.T) {
+	values := map[pixelCoord]int{
+		{0, 0}: 1,
+		{1, 0}: 1000,
+	}
+	lin := linearScale(values)
+	log := logScale(values)
+	// logScale should compress the low value upward relative to a linear mapping,
+	// since that's the entire point of offering it (a few huge RTTs/port counts
+	// shouldn't wash out everything else on the heatmap).
+	if log[pixelCoord{0, 0}] <= lin[pixelCoord{0, 0}] {
+		t.Errorf("logScale(1) = %d, want it to rank above linearScale(1) = %d", log[pixelCoord{0, 0}], lin[pixelCoord{0, 0}])
+	}
+	if log[pixelCoord{1, 0}] != math.MaxUint16 {
+		t.Errorf("logScale max = %d, want %d", log[pixelCoord{1, 0}], math.MaxUint16)
+	}
+}
+
+func TestPercentileScale(t *testing.T) {
+	values := map[pixelCoord]int{
+		{0, 0}: 5,
+		{1, 0}: 1,
+		{2, 0}: 1000,
+	}
+	got := percentileScale(values)
+	if got[pixelCoord{1, 0}] != 0 {
+		t.Errorf("percentileScale of the smallest value = %d, want 0", got[pixelCoord{1, 0}])
+	}
+	if got[pixelCoord{2, 0}] != math.MaxUint16 {
+		t.Errorf("percentileScale of the largest value = %d, want %d", got[pixelCoord{2, 0}], math.MaxUint16)
+	}
+	if mid := got[pixelCoord{0, 0}]; mid <= got[pixelCoord{1, 0}] || mid >= got[pixelCoord{2, 0}] {
+		t.Errorf("percentileScale of the middle value = %d, want it strictly between %d and %d", mid, got[pixelCoord{1, 0}], got[pixelCoord{2, 0}])
+	}
+}
+
+func TestPercentileScaleSingleValue(t *testing.T) {
+	values := map[pixelCoord]int{{0, 0}: 42}
+	got := percentileScale(values)
+	if got[pixelCoord{0, 0}] != 0 {
+		t.Errorf("percentileScale single value = %d, want 0 (must not divide by zero)", got[pixelCoord{0, 0}])
+	}
+}
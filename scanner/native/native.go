@@ -0,0 +1,363 @@
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package native implements a pure-Go scanner backend, an alternative to shelling
+// out to nmap. Host discovery is done with concurrent ICMP echo over unprivileged
+// datagram ICMP sockets, falling back to a TCP connect probe when such a socket can't
+// be opened; port scans always use TCP connect, so they work fully unprivileged.
+package native
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Host represents a single finding from a native scan.
+type Host struct {
+	IP        net.IP
+	RTT       int // microseconds, matching the unit nmap's srtt uses
+	OpenPorts []string
+}
+
+// ScanType defines which probes a scan runs, mirroring the nmap-backed scan types.
+type ScanType string
+
+const (
+	// ScanHostUp does host discovery only, no port scan.
+	ScanHostUp ScanType = "HOSTUP"
+
+	// ScanDefaultPorts scans a fixed list of commonly open ports.
+	ScanDefaultPorts ScanType = "DEFAULTPORTS"
+
+	// ScanAllPorts scans every port from 1 to 65535.
+	ScanAllPorts ScanType = "ALLPORTS"
+
+	// ScanWebPorts scans port 80 and 443.
+	ScanWebPorts ScanType = "WEBPORTS"
+)
+
+// defaultPorts covers the ports most commonly found open on a host, similar in spirit
+// to nmap's own default port list but much shorter since every port here is probed
+// with a full TCP connect rather than nmap's raw SYN scan.
+var defaultPorts = []int{21, 22, 23, 25, 53, 80, 110, 111, 135, 139, 143, 443, 445, 993, 995, 3306, 3389, 8080}
+
+var webPorts = []int{80, 443}
+
+// Options configures a native Scan.
+type Options struct {
+	// Parallelism is how many hosts are probed concurrently. Defaults to 256.
+	Parallelism int
+
+	// Timeout bounds every individual probe (ICMP echo or TCP connect). Defaults to
+	// 300ms, matching the --max-rtt-timeout the nmap backend passes to nmap.
+	Timeout time.Duration
+}
+
+func (o Options) parallelism() int {
+	if o.Parallelism <= 0 {
+		return 256
+	}
+	return o.Parallelism
+}
+
+func (o Options) timeout() time.Duration {
+	if o.Timeout <= 0 {
+		return 300 * time.Millisecond
+	}
+	return o.Timeout
+}
+
+// Scan runs a native scan against every host in n and returns a Host for every one
+// that responds; for port scans, only responding hosts with at least one open port
+// are returned. Networks larger than a /16 equivalent are rejected: sweeping more
+// hosts than that with unicast probes from a single process isn't practical, and
+// operators with bigger networks should use the nmap backend instead.
+func Scan(n *net.IPNet, t ScanType, opts Options) ([]Host, error) {
+	var ports []int
+	switch t {
+	case ScanHostUp:
+		// No ports, host discovery only.
+	case ScanWebPorts:
+		ports = webPorts
+	case ScanDefaultPorts:
+		ports = defaultPorts
+	case ScanAllPorts:
+		ports = make([]int, 65535)
+		for i := range ports {
+			ports[i] = i + 1
+		}
+	default:
+		return nil, fmt.Errorf("unknown scan type: %v", t)
+	}
+
+	ips, err := hostsInNetwork(n)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newPinger()
+	defer p.Close()
+
+	sem := make(chan struct{}, opts.parallelism())
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var hosts []Host
+
+	for _, ip := range ips {
+		ip := ip
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			up, rtt := p.Ping(ip, opts.timeout())
+			if !up {
+				return
+			}
+			h := Host{IP: ip, RTT: int(rtt.Microseconds())}
+			if len(ports) > 0 {
+				h.OpenPorts = scanPorts(ip, ports, opts.timeout())
+				if len(h.OpenPorts) == 0 {
+					return
+				}
+			}
+			mu.Lock()
+			hosts = append(hosts, h)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return hosts, nil
+}
+
+// hostsInNetwork enumerates every host address in n.
+func hostsInNetwork(n *net.IPNet) ([]net.IP, error) {
+	ones, bits := n.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("network %v is too large for the native backend (max /16 equivalent), use --backend=nmap instead", n)
+	}
+	var ips []net.IP
+	for ip := n.IP.Mask(n.Mask); n.Contains(ip); ip = nextIP(ip) {
+		dup := make(net.IP, len(ip))
+		copy(dup, ip)
+		ips = append(ips, dup)
+	}
+	return ips, nil
+}
+
+// nextIP returns the address immediately following ip.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// scanPorts runs a TCP connect scan against every port in ports and returns the ones
+// found open, e.g. "tcp/80".
+func scanPorts(ip net.IP, ports []int, timeout time.Duration) []string {
+	var open []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 64)
+	for _, port := range ports {
+		port := port
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			addr := net.JoinHostPort(ip.String(), fmt.Sprintf("%d", port))
+			conn, err := net.DialTimeout("tcp", addr, timeout)
+			if err != nil {
+				return
+			}
+			conn.Close()
+			mu.Lock()
+			open = append(open, fmt.Sprintf("tcp/%d", port))
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return open
+}
+
+// pinger performs ICMP echo host discovery over unprivileged datagram ("udp") ICMP
+// sockets, falling back to a TCP connect probe against port 80 as a liveness proxy
+// when those sockets can't be opened. Each conn has exactly one reader goroutine
+// (see readLoop): icmp.PacketConn doesn't let a caller target a read at a specific
+// peer, so if every concurrent Ping read the shared socket directly, a reply could
+// be delivered to whichever goroutine's ReadFrom happened to be waiting rather than
+// the one that actually sent the matching request. Replies are demultiplexed by echo
+// sequence number to the waiting pingICMP call instead.
+type pinger struct {
+	conn4 *icmp.PacketConn
+	conn6 *icmp.PacketConn
+	seq   uint32
+
+	mu      sync.Mutex
+	waiting map[int]chan net.IP
+}
+
+func newPinger() *pinger {
+	p := &pinger{waiting: map[int]chan net.IP{}}
+	if conn, err := icmp.ListenPacket("udp4", "0.0.0.0"); err == nil {
+		p.conn4 = conn
+		go p.readLoop(conn, 1, ipv4.ICMPTypeEchoReply)
+	}
+	if conn, err := icmp.ListenPacket("udp6", "::"); err == nil {
+		p.conn6 = conn
+		go p.readLoop(conn, 58, ipv6.ICMPTypeEchoReply)
+	}
+	return p
+}
+
+func (p *pinger) Close() {
+	if p.conn4 != nil {
+		p.conn4.Close()
+	}
+	if p.conn6 != nil {
+		p.conn6.Close()
+	}
+}
+
+// readLoop is the single reader for conn, dispatching every echo reply it sees to
+// whichever pingICMP call is waiting on that sequence number, if any.
+func (p *pinger) readLoop(conn *icmp.PacketConn, proto int, reply icmp.Type) {
+	rb := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(rb)
+		if err != nil {
+			return // conn closed
+		}
+		rm, err := icmp.ParseMessage(proto, rb[:n])
+		if err != nil || rm.Type != reply {
+			continue
+		}
+		echo, ok := rm.Body.(*icmp.Echo)
+		if !ok {
+			continue
+		}
+		peerUDP, ok := peer.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		p.mu.Lock()
+		ch := p.waiting[echo.Seq]
+		p.mu.Unlock()
+		if ch == nil {
+			continue
+		}
+		select {
+		case ch <- peerUDP.IP:
+		default:
+		}
+	}
+}
+
+// register records that seq is awaiting a reply and returns the channel readLoop
+// will signal on, then unregister removes it once the caller is done waiting.
+func (p *pinger) register(seq int) chan net.IP {
+	ch := make(chan net.IP, 1)
+	p.mu.Lock()
+	p.waiting[seq] = ch
+	p.mu.Unlock()
+	return ch
+}
+
+func (p *pinger) unregister(seq int) {
+	p.mu.Lock()
+	delete(p.waiting, seq)
+	p.mu.Unlock()
+}
+
+// Ping reports whether ip is up and, if so, the round-trip time observed.
+func (p *pinger) Ping(ip net.IP, timeout time.Duration) (bool, time.Duration) {
+	if ip.To4() != nil {
+		if p.conn4 == nil {
+			return p.pingViaTCP(ip, timeout)
+		}
+		return p.pingICMP(p.conn4, &net.UDPAddr{IP: ip.To4()}, ipv4.ICMPTypeEcho, ip, timeout)
+	}
+	if p.conn6 == nil {
+		return p.pingViaTCP(ip, timeout)
+	}
+	return p.pingICMP(p.conn6, &net.UDPAddr{IP: ip}, ipv6.ICMPTypeEchoRequest, ip, timeout)
+}
+
+// pingICMP sends one ICMP echo request over conn and waits for readLoop to hand back
+// the matching reply.
+func (p *pinger) pingICMP(conn *icmp.PacketConn, dst net.Addr, req icmp.Type, want net.IP, timeout time.Duration) (bool, time.Duration) {
+	// icmp.Echo.Seq is wire-encoded as 16 bits, so the registered key has to wrap the
+	// same way or a reply's unmarshaled Seq will stop matching once more than 65535
+	// pings have gone out in this process's lifetime.
+	seq := int(atomic.AddUint32(&p.seq, 1) & 0xffff)
+	msg := icmp.Message{
+		Type: req,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   os.Getpid() & 0xffff,
+			Seq:  seq,
+			Data: []byte("netmap"),
+		},
+	}
+	wb, err := msg.Marshal(nil)
+	if err != nil {
+		return p.pingViaTCP(want, timeout)
+	}
+
+	ch := p.register(seq)
+	defer p.unregister(seq)
+
+	start := time.Now()
+	if _, err := conn.WriteTo(wb, dst); err != nil {
+		return p.pingViaTCP(want, timeout)
+	}
+
+	select {
+	case peer := <-ch:
+		if !peer.Equal(want) {
+			return false, 0
+		}
+		return true, time.Since(start)
+	case <-time.After(timeout):
+		return false, 0
+	}
+}
+
+// pingViaTCP uses a TCP connect to port 80 as a liveness proxy when ICMP isn't
+// available.
+func (p *pinger) pingViaTCP(ip net.IP, timeout time.Duration) (bool, time.Duration) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), "80"), timeout)
+	if err != nil {
+		return false, 0
+	}
+	conn.Close()
+	return true, time.Since(start)
+}
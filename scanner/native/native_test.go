@@ -0,0 +1,70 @@
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package native
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// TestEchoSeqWireWraps confirms the wire encoding icmp.Echo.Seq actually wraps mod
+// 65536, which is why pingICMP masks its registration key with & 0xffff: the unmarshaled
+// reply's Seq never reflects the unmasked, ever-growing atomic counter.
+func TestEchoSeqWireWraps(t *testing.T) {
+	tests := []int{0, 1, 65535, 65536, 65537, 131072 + 42}
+	for _, seq := range tests {
+		msg := icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Code: 0,
+			Body: &icmp.Echo{ID: 1, Seq: seq, Data: []byte("netmap")},
+		}
+		wb, err := msg.Marshal(nil)
+		if err != nil {
+			t.Fatalf("Marshal(%d): %s", seq, err)
+		}
+		rm, err := icmp.ParseMessage(1, wb)
+		if err != nil {
+			t.Fatalf("ParseMessage(%d): %s", seq, err)
+		}
+		echo, ok := rm.Body.(*icmp.Echo)
+		if !ok {
+			t.Fatalf("ParseMessage(%d) body is %T, want *icmp.Echo", seq, rm.Body)
+		}
+		if want := seq & 0xffff; echo.Seq != want {
+			t.Errorf("round-tripped Seq for %d = %d, want %d (seq & 0xffff)", seq, echo.Seq, want)
+		}
+	}
+}
+
+func TestPingerRegisterUnregister(t *testing.T) {
+	p := &pinger{waiting: map[int]chan net.IP{}}
+
+	ch := p.register(5)
+	p.mu.Lock()
+	if p.waiting[5] != ch {
+		t.Errorf("register(5) did not store the channel it returned")
+	}
+	p.mu.Unlock()
+
+	p.unregister(5)
+	p.mu.Lock()
+	if _, ok := p.waiting[5]; ok {
+		t.Errorf("unregister(5) left the channel registered")
+	}
+	p.mu.Unlock()
+}
@@ -0,0 +1,257 @@
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image/png"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rescaleEvery is how many hosts scanLoop processes between full scaleValues passes.
+// Keeps the O(N log N)-or-worse scaleValues call (percentile scale sorts every pixel)
+// off the hot path of every single host arrival, which matters since the native
+// backend's own cap is a /16 equivalent (65536 hosts).
+const rescaleEvery = 256
+
+// pixelUpdate is the message pushed to browsers in --serve mode as individual hosts
+// are found, rather than only once a full scan completes.
+type pixelUpdate struct {
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+	RGBA [4]int `json:"rgba"`
+}
+
+// broadcaster fans pixelUpdate messages out to every connected SSE client.
+type broadcaster struct {
+	mu      sync.Mutex
+	clients map[chan pixelUpdate]bool
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{clients: map[chan pixelUpdate]bool{}}
+}
+
+func (b *broadcaster) subscribe() chan pixelUpdate {
+	ch := make(chan pixelUpdate, 64)
+	b.mu.Lock()
+	b.clients[ch] = true
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *broadcaster) unsubscribe(ch chan pixelUpdate) {
+	b.mu.Lock()
+	delete(b.clients, ch)
+	b.mu.Unlock()
+	close(ch)
+}
+
+// publish sends u to every subscribed client, dropping it for clients that are
+// falling behind rather than blocking the scan loop; the next full rescan repaints
+// anything a slow client missed.
+func (b *broadcaster) publish(u pixelUpdate) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.clients {
+		select {
+		case ch <- u:
+		default:
+		}
+	}
+}
+
+func (b *broadcaster) serveSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := b.subscribe()
+	defer b.unsubscribe(ch)
+
+	for {
+		select {
+		case u, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(u)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveLive starts a long-running HTTP server that keeps rescanning n and streams
+// pixel deltas to connected browsers over SSE as each host result arrives, rather
+// than writing a single image and exiting like the default mode does.
+func serveLive(addr string, n *net.IPNet, st ScanType, transparent bool, pal Palette, scale ScaleMode, refresh time.Duration) error {
+	canvas, err := NewCanvas(n, transparent, pal)
+	if err != nil {
+		return err
+	}
+	b := newBroadcaster()
+
+	go scanLoop(canvas, b, n, st, scale, refresh)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", serveIndex(canvas.l))
+	mux.HandleFunc("/snapshot.png", serveSnapshot(canvas))
+	mux.HandleFunc("/events", b.serveSSE)
+
+	log.Printf("serving live heatmap for %s on %s (full rescan every %s)", n, addr, refresh)
+	return http.ListenAndServe(addr, mux)
+}
+
+// scanLoop runs a scan of n every refresh interval, feeding every host into canvas
+// and out to subscribed browsers as soon as the scan backend reports it. Each arrival
+// gets an O(1) running-max linear estimate for immediate feedback; every rescaleEvery
+// hosts, and once more at the end of the scan, a full scaleValues pass (the same one
+// renderImage/writeSVG use) corrects any pixel that estimate left under-scaled, but
+// only changed pixels are republished rather than the whole accumulated set.
+func scanLoop(canvas *Canvas, b *broadcaster, n *net.IPNet, st ScanType, scale ScaleMode, refresh time.Duration) {
+	for {
+		start := time.Now()
+		values := map[pixelCoord]int{}
+		reps := map[pixelCoord]net.IP{}
+		lvls := map[pixelCoord]uint16{}
+		max := 1
+		processed := 0
+
+		publish := func(pc pixelCoord, lvl uint16) {
+			lvls[pc] = lvl
+			x, y, col, err := canvas.UpdatePixel(reps[pc], lvl)
+			if err != nil {
+				return
+			}
+			b.publish(pixelUpdate{X: x, Y: y, RGBA: [4]int{int(col.R), int(col.G), int(col.B), int(col.A)}})
+		}
+
+		rescale := func() {
+			for pc, lvl := range scaleValues(values, scale) {
+				if cur, ok := lvls[pc]; ok && cur == lvl {
+					continue
+				}
+				publish(pc, lvl)
+			}
+		}
+
+		onHost := func(h Host) {
+			idx := bucketIndex(n, h.IP, canvas.l)
+			x, y, err := canvas.hil.Map(int(idx.Int64()))
+			if err != nil {
+				return
+			}
+			pc := pixelCoord{x, y}
+			v := h.RTT
+			if st != ScanHostUp {
+				v = len(h.OpenPorts)
+			}
+			values[pc] += v
+			reps[pc] = h.IP
+			if values[pc] > max {
+				max = values[pc]
+			}
+			publish(pc, uint16(values[pc]*int(math.MaxUint16)/max))
+
+			processed++
+			if processed%rescaleEvery == 0 {
+				rescale()
+			}
+		}
+		if err := ScanStream(n, st, onHost); err != nil {
+			log.Printf("scan failed: %s", err)
+		}
+		rescale()
+		time.Sleep(time.Until(start.Add(refresh)))
+	}
+}
+
+// indexTmpl is the embedded HTML/JS client. It paints the canvas at one screen pixel
+// per heatmap pixel and repaints individual pixels as {x,y,rgba} events arrive over
+// the /events SSE stream.
+var indexTmpl = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>netmap live heatmap</title>
+  <style>
+    body { background: #111; margin: 0; }
+    canvas { display: block; margin: 0 auto; image-rendering: pixelated; }
+  </style>
+</head>
+<body>
+  <canvas id="heatmap" width="{{.}}" height="{{.}}"></canvas>
+  <script>
+    var canvas = document.getElementById("heatmap");
+    var ctx = canvas.getContext("2d");
+
+    function subscribe() {
+      var events = new EventSource("/events");
+      events.onmessage = function(e) {
+        var u = JSON.parse(e.data);
+        ctx.fillStyle = "rgba(" + u.rgba[0] + "," + u.rgba[1] + "," + u.rgba[2] + "," + (u.rgba[3] / 255) + ")";
+        ctx.fillRect(u.x, u.y, 1, 1);
+      };
+    }
+
+    // Paint whatever the scan has already found before subscribing to deltas, so a
+    // client connecting mid-scan doesn't sit on a blank canvas until the next refresh.
+    var snapshot = new Image();
+    snapshot.onload = function() {
+      ctx.drawImage(snapshot, 0, 0);
+      subscribe();
+    };
+    snapshot.onerror = subscribe;
+    snapshot.src = "/snapshot.png";
+  </script>
+</body>
+</html>
+`))
+
+// serveIndex renders the embedded HTML/JS client, sized for an l x l heatmap.
+func serveIndex(l int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		indexTmpl.Execute(w, l)
+	}
+}
+
+// serveSnapshot returns canvas's current state as a PNG, so a browser connecting
+// mid-scan can paint everything found so far instead of starting from a blank canvas
+// and waiting for the next full refresh to catch up.
+func serveSnapshot(canvas *Canvas) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, canvas.Image())
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2016 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/google/hilbert"
+)
+
+// svgPixel aggregates the hosts that bucket onto a single pixel (see bucketIndex)
+// for SVG rendering: value drives its color like renderImage, while host carries
+// the tooltip content and is just the first host seen for that pixel.
+type svgPixel struct {
+	value int
+	host  Host
+}
+
+// writeSVG renders a network's hosts as an SVG heatmap, where every colored pixel is
+// an addressable <rect> with a <title> tooltip carrying the host's IP, RTT, open
+// ports and reverse-DNS name. Unlike the PNG/JPEG output, this makes the heatmap
+// navigable in a browser, which is the main usability complaint once a network is
+// bigger than a /24. It doesn't go through NewCanvas/Canvas since it never needs a
+// raster image, only the same sizing and pixel mapping NewCanvas would give it; every
+// pixel with no host starts at pal.Color(0), same as the PNG/JPEG non-transparent
+// output, via a single background <rect> instead of per-pixel fills, unless
+// transparent is set, honoring --transparent the same way renderImage/NewCanvas do.
+func writeSVG(path string, n *net.IPNet, st ScanType, hosts []Host, scale ScaleMode, pal Palette, transparent bool) error {
+	l, err := getLength(n)
+	if err != nil {
+		return err
+	}
+	hil, err := hilbert.NewHilbert(l)
+	if err != nil {
+		return fmt.Errorf("unable to create hilbert map for given network: %s", err)
+	}
+
+	values := map[pixelCoord]int{}
+	pixels := map[pixelCoord]*svgPixel{}
+	for _, h := range hosts {
+		idx := bucketIndex(n, h.IP, l)
+		x, y, err := hil.Map(int(idx.Int64()))
+		if err != nil {
+			continue
+		}
+		v := h.RTT
+		if st != ScanHostUp {
+			v = len(h.OpenPorts)
+		}
+		pc := pixelCoord{x, y}
+		values[pc] += v
+		if p, ok := pixels[pc]; ok {
+			p.value += v
+		} else {
+			pixels[pc] = &svgPixel{value: v, host: h}
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" shape-rendering=\"crispEdges\">\n", l, l)
+	if !transparent {
+		bg := pal.Color(0)
+		fmt.Fprintf(f, "  <rect x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" fill=\"rgb(%d,%d,%d)\"/>\n", l, l, bg.R, bg.G, bg.B)
+	}
+	for pc, lvl := range scaleValues(values, scale) {
+		p := pixels[pc]
+		col := pal.Color(lvl)
+		name := p.host.Name
+		if name == "" {
+			name = "(no reverse DNS)"
+		}
+		title := fmt.Sprintf("%s\nrtt: %dus\nopen ports: %s\nname: %s", p.host.IP, p.host.RTT, strings.Join(p.host.OpenPorts, ", "), name)
+		fmt.Fprintf(f, "  <rect x=\"%d\" y=\"%d\" width=\"1\" height=\"1\" fill=\"rgb(%d,%d,%d)\"><title>%s</title></rect>\n",
+			pc.x, pc.y, col.R, col.G, col.B, xmlEscape(title))
+	}
+	fmt.Fprintln(f, "</svg>")
+	return nil
+}
+
+// xmlEscape escapes s for safe inclusion as XML character data.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}